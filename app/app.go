@@ -4,14 +4,23 @@ Package app is the main runtime package for Efs2. This package holds all of the
 package app
 
 import (
-	"efs2/config"
-	"efs2/parser"
-	"efs2/ssh"
+	"bytes"
+	"context"
 	"fmt"
 	"github.com/fatih/color"
 	"github.com/howeyc/gopass"
+	"github.com/madflojo/efs2/config"
+	"github.com/madflojo/efs2/inventory"
+	"github.com/madflojo/efs2/parser"
+	"github.com/madflojo/efs2/report"
+	"github.com/madflojo/efs2/ssh"
+	"github.com/madflojo/efs2/sshconfig"
 	"regexp"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
 )
 
 // Encrypted Key Error
@@ -20,36 +29,34 @@ var isPassErr = regexp.MustCompile(`.*[decode encrypted|protected].*$`)
 // Has a port defined
 var hasPort = regexp.MustCompile(`.*:\d*`)
 
-// Run is the primary execution function for this application.
-func Run(cfg config.Config) error {
-	var clientCfg ssh.Config
-	var err error
+// connectRetries and connectBackoff bound the exponential backoff retry applied to a host's initial SSH
+// connection, which tends to be the flakiest part of a run.
+const (
+	connectRetries = 3
+	connectBackoff = 500 * time.Millisecond
+)
 
-	// If Password is set
-	if cfg.Password != "" {
-		clientCfg = ssh.Config{
-			Password: cfg.Password,
-		}
-		cfg.KeyFile = ""
-	}
+// HostResult is the outcome of running every task against a single host.
+type HostResult struct {
+	Host    string
+	Error   error
+	Elapsed time.Duration
+}
 
-	if cfg.Password == "" {
-		// If no Password is set
-		clientCfg, err = ssh.ReadKeyFile(cfg.KeyFile, cfg.Passphrase)
-		if err != nil {
-			if !isPassErr.MatchString(err.Error()) {
-				return fmt.Errorf("Unable to obtain Key Passphrase - %s", err)
-			}
-			color.White("Enter Private Key Passphrase: ")
-			cfg.Passphrase, err = gopass.GetPasswd()
-			if err != nil {
-				return fmt.Errorf("Unable to obtain Key Passphrase - %s", err)
-			}
-			clientCfg, err = ssh.ReadKeyFile(cfg.KeyFile, cfg.Passphrase)
-			if err != nil {
-				return fmt.Errorf("Unable to read keyfile - %s", err)
-			}
-		}
+// RunResult is the structured outcome of a full Efs2 run, returned by Run so callers can
+// programmatically consume results instead of scraping log output.
+type RunResult struct {
+	Hosts    []HostResult
+	Changed  int
+	Failed   int
+	Elapsed  time.Duration
+}
+
+// Run is the primary execution function for this application.
+func Run(cfg config.Config) (RunResult, error) {
+	clientCfg, err := resolveAuth(&cfg)
+	if err != nil {
+		return RunResult{}, err
 	}
 
 	// Check if Efs2file is defined
@@ -60,6 +67,13 @@ func Run(cfg config.Config) error {
 	// Setup User
 	clientCfg.User = cfg.User
 
+	// Resolve host key verification once for the whole run; every per-host ssh.Config is copied from
+	// clientCfg so they all inherit it.
+	clientCfg.HostKeyCallback, err = ssh.KnownHostsCallback(cfg.InsecureHostKey)
+	if err != nil {
+		return RunResult{}, err
+	}
+
 	// Loudness
 	if cfg.Verbose && !cfg.Quiet {
 		color.Yellow("SSH User: %s", cfg.User)
@@ -68,92 +82,449 @@ func Run(cfg config.Config) error {
 	}
 
 	// Parse Efs2file
-	tasks, err := parser.Parse(cfg.Efs2File)
+	parsed, err := parser.Parse(cfg.Efs2File)
 	if err != nil {
-		return fmt.Errorf("Unable to parse Efs2file - %s", err)
+		return RunResult{}, fmt.Errorf("Unable to parse Efs2file - %s", err)
+	}
+
+	// Separate out named handlers; they only run when a task's NOTIFY fires
+	var tasks []ssh.Task
+	handlers := map[string]ssh.Task{}
+	for _, t := range parsed {
+		if t.IsHandler {
+			handlers[t.Name] = t
+			continue
+		}
+		tasks = append(tasks, t)
+	}
+
+	// Apply --limit to the host list
+	cfg.Hosts = inventory.Filter(cfg.Hosts, cfg.Limit)
+
+	// Set up reporting
+	rep, closeReport, err := report.New(cfg.Output, cfg.ReportPath)
+	if err != nil {
+		return RunResult{}, err
+	}
+	defer closeReport()
+
+	start := time.Now()
+
+	// Bound concurrency with a worker pool; forks of 1 runs hosts strictly sequentially
+	forks := cfg.Forks
+	if forks <= 0 {
+		forks = 5
+	}
+	if !cfg.Parallel {
+		forks = 1
 	}
+	sem := make(chan struct{}, forks)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-	// Fixup Hosts
-	cfg.Hosts = fixUpHosts(cfg.Hosts, cfg.Port)
+	var errCount, changedCount int64
+	results := make(chan HostResult, len(cfg.Hosts))
 
-	// Execute
 	var wg sync.WaitGroup
-	var errCount int
 	for _, h := range cfg.Hosts {
 		wg.Add(1)
-		go func() {
+		sem <- struct{}{}
+		go func(h config.Host) {
 			defer wg.Done()
-			c := clientCfg
-			c.Host = h
-			sh, err := ssh.Dial(c)
+			defer func() { <-sem }()
+
+			hostStart := time.Now()
+			err := runHost(ctx, cfg, clientCfg, h, tasks, handlers, rep, &changedCount)
+			results <- HostResult{Host: h.Name, Error: err, Elapsed: time.Since(hostStart)}
 			if err != nil {
-				errCount = errCount + 1
-				if !cfg.Quiet {
-					color.Red("%s: Error connecting to host - %s", h, err)
+				atomic.AddInt64(&errCount, 1)
+				if cfg.FailFast {
+					cancel()
 				}
-				return
 			}
-			for i, t := range tasks {
-				if !cfg.Quiet {
-					color.Blue("%s: Executing Task %d - %s", h, i, t.Task)
-				}
-				if cfg.DryRun {
-					continue
-				}
-				if t.File.Source != "" {
-					err := sh.Put(t.File)
-					if err != nil {
-						errCount = errCount + 1
-						if !cfg.Quiet {
-							color.Red("%s: Error uploading file - %s", h, err)
-						}
-						return
-					}
-					if !cfg.Quiet {
-						color.Blue("%s: File upload successful", h)
-					}
-				}
-				if t.Command.Cmd != "" {
-					r, err := sh.Run(t.Command)
-					if err != nil {
-						errCount = errCount + 1
-						if !cfg.Quiet {
-							color.Red("%s: Error executing command - %s", h, err)
-						}
-						return
-					}
-					if !cfg.Quiet {
-						color.Blue("%s: %s", h, r)
-					}
-				}
+		}(h)
+	}
+	wg.Wait()
+	close(results)
+
+	result := RunResult{Changed: int(changedCount), Failed: int(errCount), Elapsed: time.Since(start)}
+	for r := range results {
+		result.Hosts = append(result.Hosts, r)
+	}
+
+	rep.Summary(report.Summary{
+		Hosts:    len(cfg.Hosts),
+		Tasks:    len(tasks),
+		Changed:  result.Changed,
+		Failed:   result.Failed,
+		Duration: result.Elapsed,
+	})
+
+	if result.Failed > 0 {
+		return result, fmt.Errorf("Execution failed with %d errors", result.Failed)
+	}
+	return result, nil
+}
+
+// runHost connects to h and executes every task against it, honoring ctx cancellation between tasks so a
+// fail-fast run stops queued work without killing an already in-flight command. Connection settings are
+// resolved with the following precedence: inventory host overrides, then ~/.ssh/config, then the global
+// Config, then a hardcoded default.
+func runHost(ctx context.Context, cfg config.Config, clientCfg ssh.Config, h config.Host, tasks []ssh.Task, handlers map[string]ssh.Task, rep report.Reporter, changedCount *int64) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	sc, err := sshconfig.Resolve(h.Name)
+	if err != nil {
+		if !cfg.Quiet {
+			rep.ConnectError(h.Name, err)
+		}
+		return err
+	}
+
+	name := h.Name
+	if sc.HostName != "" {
+		name = sc.HostName
+	}
+
+	port := cfg.Port
+	if sc.Port != "" {
+		port = sc.Port
+	}
+	if h.Port != "" {
+		port = h.Port
+	}
+
+	c := clientCfg
+	c.Host = hostAddr(name, port)
+	if sc.User != "" {
+		c.User = sc.User
+	}
+	if h.User != "" {
+		c.User = h.User
+	}
+	if sc.ProxyJump != "" {
+		c.ProxyJump = sc.ProxyJump
+	}
+
+	keyFile := h.KeyFile
+	if keyFile == "" && sc.IdentityFile != "" {
+		keyFile = sc.IdentityFile
+	}
+	if keyFile != "" && keyFile != cfg.KeyFile {
+		hc, err := ssh.ReadKeyFile(keyFile, cfg.Passphrase)
+		if err != nil {
+			if !cfg.Quiet {
+				rep.ConnectError(h.Name, err)
 			}
+			return err
+		}
+		c.Signers = hc.Signers
+	}
 
-		}()
-		if !cfg.Parallel {
-			wg.Wait()
+	sh, err := dialWithBackoff(c, connectRetries, connectBackoff)
+	if err != nil {
+		if !cfg.Quiet {
+			rep.ConnectError(h.Name, err)
 		}
+		return err
 	}
-	wg.Wait()
+	defer sh.Close()
+
+	notified := map[string]bool{}
+	for i, t := range tasks {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if cfg.DryRun {
+			if !cfg.Quiet {
+				rep.TaskStart(h.Name, i, t.Task)
+			}
+			continue
+		}
 
-	if errCount > 0 {
-		return fmt.Errorf("Execution failed with %d errors", errCount)
+		changed, failed := execTask(sh, h, i, t, rep, cfg.Quiet)
+		if failed {
+			return fmt.Errorf("task %d failed", i)
+		}
+		if changed {
+			atomic.AddInt64(changedCount, 1)
+			for _, name := range t.Notify {
+				notified[name] = true
+			}
+		}
 	}
+
+	// Run any handler that was notified by a task that changed something, once each
+	for name, fired := range notified {
+		ht, ok := handlers[name]
+		if !fired || !ok {
+			continue
+		}
+		changed, failed := execTask(sh, h, -1, ht, rep, cfg.Quiet)
+		if failed {
+			return fmt.Errorf("handler %s failed", name)
+		}
+		if changed {
+			atomic.AddInt64(changedCount, 1)
+		}
+	}
+
 	return nil
 }
 
-func fixUpHosts(hosts []string, port string) []string {
-	// Fixup Hosts
-	var hh []string
-	for _, h := range hosts {
-		if hasPort.MatchString(h) {
-			hh = append(hh, h)
-			continue
+// sshClient is the subset of *ssh.Client that runHost and execTask depend on. It exists so tests can
+// substitute a fake connection without dialing a real host.
+type sshClient interface {
+	Run(ssh.Command) (string, error)
+	Put(ssh.File, map[string]string) (bool, error)
+	Check(string) (bool, error)
+	Close() error
+}
+
+// dial opens a connection for a run. Tests override this to avoid real network access.
+var dial = func(c ssh.Config) (sshClient, error) {
+	return ssh.Dial(c)
+}
+
+// dialWithBackoff dials c, retrying up to retries additional times with exponential backoff starting at
+// delay if the connection attempt fails.
+func dialWithBackoff(c ssh.Config, retries int, delay time.Duration) (sshClient, error) {
+	var lastErr error
+	for attempt := 0; attempt <= retries; attempt++ {
+		sh, err := dial(c)
+		if err == nil {
+			return sh, nil
 		}
-		if port == "" {
-			hh = append(hh, h+":22")
-			continue
+		lastErr = err
+		if attempt < retries {
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}
+	return nil, lastErr
+}
+
+// execTask runs a single task against an already-connected host, honoring its WHEN/UNLESS guard and
+// RETRY modifier, and reports its outcome. It returns whether the task changed something on the host and
+// whether it failed.
+func execTask(sh sshClient, h config.Host, i int, t ssh.Task, rep report.Reporter, quiet bool) (changed bool, failed bool) {
+	if !quiet {
+		rep.TaskStart(h.Name, i, t.Task)
+	}
+
+	if t.When != "" {
+		ok, err := evalGuard(sh, t.When, h.Vars)
+		if err != nil {
+			reportErr(rep, h.Name, i, t, quiet, err)
+			return false, true
+		}
+		if !ok {
+			return false, false
+		}
+	}
+	if t.Unless != "" {
+		ok, err := evalGuard(sh, t.Unless, h.Vars)
+		if err != nil {
+			reportErr(rep, h.Name, i, t, quiet, err)
+			return false, true
+		}
+		if ok {
+			return false, false
+		}
+	}
+
+	start := time.Now()
+
+	if t.File.Source != "" {
+		dest, err := renderVars(t.File.Destination, h.Vars)
+		if err != nil {
+			reportErr(rep, h.Name, i, t, quiet, err)
+			return false, true
 		}
-		hh = append(hh, h+":"+port)
+		t.File.Destination = dest
+
+		var fileChanged bool
+		putErr := withRetry(t.Retries, t.RetryDelay, func() error {
+			var err error
+			fileChanged, err = sh.Put(t.File, h.Vars)
+			return err
+		})
+
+		r := report.Result{Host: h.Name, Task: i, TaskText: t.Task, Start: start, End: time.Now()}
+		r.Duration = r.End.Sub(r.Start)
+		if putErr != nil {
+			r.Failed = true
+			r.ExitCode = 1
+			r.Stderr = putErr.Error()
+			if !quiet {
+				rep.TaskDone(r)
+			}
+			return false, true
+		}
+		r.Changed = fileChanged
+		r.Stdout = "File upload successful"
+		if !quiet {
+			rep.TaskDone(r)
+		}
+		changed = changed || fileChanged
+	}
+
+	if t.Command.Cmd != "" {
+		cmd, err := renderVars(t.Command.Cmd, h.Vars)
+		if err != nil {
+			reportErr(rep, h.Name, i, t, quiet, err)
+			return changed, true
+		}
+		cmd = withEnv(cmd, t.Env)
+
+		var out string
+		runErr := withRetry(t.Retries, t.RetryDelay, func() error {
+			var err error
+			out, err = sh.Run(ssh.Command{Cmd: cmd})
+			return err
+		})
+
+		r := report.Result{Host: h.Name, Task: i, TaskText: t.Task, Start: start, End: time.Now(), ExitCode: ssh.ExitCode(runErr)}
+		r.Duration = r.End.Sub(r.Start)
+		if runErr != nil {
+			r.Failed = true
+			r.Stderr = runErr.Error()
+			if !quiet {
+				rep.TaskDone(r)
+			}
+			return changed, true
+		}
+		r.Changed = true
+		r.Stdout = out
+		if !quiet {
+			rep.TaskDone(r)
+		}
+		changed = true
+	}
+
+	return changed, false
+}
+
+// reportErr reports a task failure that happened before its Command or File work could be attempted.
+func reportErr(rep report.Reporter, host string, i int, t ssh.Task, quiet bool, err error) {
+	if quiet {
+		return
+	}
+	now := time.Now()
+	rep.TaskDone(report.Result{Host: host, Task: i, TaskText: t.Task, Failed: true, Stderr: err.Error(), Start: now, End: now})
+}
+
+// evalGuard renders test against vars and runs it on the host, reporting whether it exited 0.
+func evalGuard(sh sshClient, test string, vars map[string]string) (bool, error) {
+	rendered, err := renderVars(test, vars)
+	if err != nil {
+		return false, err
 	}
-	return hh
+	return sh.Check(rendered)
+}
+
+// withRetry calls fn, retrying up to retries additional times with delay between attempts, until it
+// succeeds or the attempts are exhausted.
+func withRetry(retries int, delay time.Duration, fn func() error) error {
+	var err error
+	for attempt := 0; attempt <= retries; attempt++ {
+		if err = fn(); err == nil {
+			return nil
+		}
+		if attempt < retries {
+			time.Sleep(delay)
+		}
+	}
+	return err
+}
+
+// withEnv prepends cmd with shell export statements for every variable declared via ENV.
+func withEnv(cmd string, env map[string]string) string {
+	if len(env) == 0 {
+		return cmd
+	}
+
+	var b strings.Builder
+	for k, v := range env {
+		fmt.Fprintf(&b, "export %s=%s; ", k, ssh.ShellQuote(v))
+	}
+	b.WriteString(cmd)
+	return b.String()
+}
+
+// resolveAuth builds the base ssh.Config for the run from cfg, prompting for a key passphrase if the
+// configured key is encrypted and none was supplied.
+func resolveAuth(cfg *config.Config) (ssh.Config, error) {
+	if cfg.Password != "" {
+		cfg.KeyFile = ""
+		return ssh.Config{Password: cfg.Password}, nil
+	}
+
+	clientCfg, err := ssh.ReadKeyFile(cfg.KeyFile, cfg.Passphrase)
+	if err != nil {
+		if !strings.Contains(err.Error(), "decode encrypted") && !strings.Contains(err.Error(), "protected") {
+			if cfg.KeyFile != "" {
+				// The user explicitly asked for this key with --key; report why it couldn't be used
+				// instead of silently authenticating with whatever the agent happens to offer.
+				return ssh.Config{}, fmt.Errorf("Unable to read keyfile - %s", err)
+			}
+			// No key was requested and the default ~/.ssh/id_rsa isn't usable - fall back to a running
+			// ssh-agent before giving up.
+			agentCfg, agentErr := ssh.AgentConfig()
+			if agentErr == nil {
+				return agentCfg, nil
+			}
+			return ssh.Config{}, fmt.Errorf("Unable to obtain Key Passphrase - %s", err)
+		}
+		color.White("Enter Private Key Passphrase: ")
+		passwd, err := gopass.GetPasswd()
+		if err != nil {
+			return ssh.Config{}, fmt.Errorf("Unable to obtain Key Passphrase - %s", err)
+		}
+		cfg.Passphrase = string(passwd)
+		clientCfg, err = ssh.ReadKeyFile(cfg.KeyFile, cfg.Passphrase)
+		if err != nil {
+			return ssh.Config{}, fmt.Errorf("Unable to read keyfile - %s", err)
+		}
+	}
+
+	return clientCfg, nil
+}
+
+// hostAddr returns name's connection address in "host:port" form, preferring a port already present in
+// name over the resolved port.
+func hostAddr(name, port string) string {
+	if hasPort.MatchString(name) {
+		return name
+	}
+
+	if port == "" {
+		port = "22"
+	}
+
+	return name + ":" + port
+}
+
+// renderVars executes s as a Go text/template using vars, so RUN and PUT lines can reference per-host
+// variables via {{ .Name }}. Lines with no template actions are returned unchanged.
+func renderVars(s string, vars map[string]string) (string, error) {
+	if !strings.Contains(s, "{{") {
+		return s, nil
+	}
+
+	t, err := template.New("line").Parse(s)
+	if err != nil {
+		return "", fmt.Errorf("could not parse %q - %s", s, err)
+	}
+
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, vars); err != nil {
+		return "", fmt.Errorf("could not render %q - %s", s, err)
+	}
+
+	return buf.String(), nil
 }
@@ -0,0 +1,171 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/madflojo/efs2/config"
+	"github.com/madflojo/efs2/ssh"
+)
+
+// fakeClient is a no-op sshClient used to drive Run without a real network connection. runErr, when set,
+// is returned by every Run call so a test can simulate a failing task.
+type fakeClient struct {
+	runErr error
+}
+
+func (f *fakeClient) Run(ssh.Command) (string, error)               { return "ok", f.runErr }
+func (f *fakeClient) Put(ssh.File, map[string]string) (bool, error) { return false, nil }
+func (f *fakeClient) Check(string) (bool, error)                    { return true, nil }
+func (f *fakeClient) Close() error                                  { return nil }
+
+// writeEfs2File writes a minimal, single-RUN-line Efs2file and returns its path, registering cleanup.
+func writeEfs2File(t *testing.T) string {
+	t.Helper()
+	f, err := os.CreateTemp(t.TempDir(), "Efs2file")
+	if err != nil {
+		t.Fatalf("could not create temp Efs2file - %s", err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString("RUN echo hi\n"); err != nil {
+		t.Fatalf("could not write temp Efs2file - %s", err)
+	}
+	return f.Name()
+}
+
+// baseConfig returns a Config that exercises Run end to end without touching real SSH auth or host key
+// files: Password takes the no-keyfile path in resolveAuth and InsecureHostKey skips known_hosts lookup.
+func baseConfig(t *testing.T, hosts []config.Host) config.Config {
+	return config.Config{
+		Efs2File:        writeEfs2File(t),
+		Password:        "testpass",
+		InsecureHostKey: true,
+		Quiet:           true,
+		Hosts:           hosts,
+	}
+}
+
+func hostsNamed(n int) []config.Host {
+	hosts := make([]config.Host, n)
+	for i := range hosts {
+		hosts[i] = config.Host{Name: fmt.Sprintf("host%d", i)}
+	}
+	return hosts
+}
+
+// TestRun_ForkLimit verifies the sem channel actually caps concurrent host connections at cfg.Forks rather
+// than just limiting how many goroutines are spawned.
+func TestRun_ForkLimit(t *testing.T) {
+	const forks = 2
+	cfg := baseConfig(t, hostsNamed(6))
+	cfg.Parallel = true
+	cfg.Forks = forks
+
+	var active, maxActive int64
+	orig := dial
+	defer func() { dial = orig }()
+	dial = func(ssh.Config) (sshClient, error) {
+		n := atomic.AddInt64(&active, 1)
+		for {
+			cur := atomic.LoadInt64(&maxActive)
+			if n <= cur || atomic.CompareAndSwapInt64(&maxActive, cur, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		atomic.AddInt64(&active, -1)
+		return &fakeClient{}, nil
+	}
+
+	result, err := Run(cfg)
+	if err != nil {
+		t.Fatalf("Run returned unexpected error: %s", err)
+	}
+	if result.Failed != 0 {
+		t.Fatalf("expected no failures, got %d", result.Failed)
+	}
+	if got := atomic.LoadInt64(&maxActive); got > forks {
+		t.Fatalf("observed %d concurrent connections, want at most %d", got, forks)
+	}
+	if got := atomic.LoadInt64(&maxActive); got < forks {
+		t.Fatalf("observed only %d concurrent connections, want the pool to reach the %d fork limit", got, forks)
+	}
+}
+
+// TestRun_FailFast verifies that once a host fails with --fail-fast set, hosts still queued behind the
+// worker pool are skipped rather than dialed.
+func TestRun_FailFast(t *testing.T) {
+	cfg := baseConfig(t, hostsNamed(3))
+	cfg.Parallel = true
+	cfg.Forks = 1 // serialize hosts so failure on the first is guaranteed to precede later dials
+	cfg.FailFast = true
+
+	var dialCount int64
+	orig := dial
+	defer func() { dial = orig }()
+	dial = func(ssh.Config) (sshClient, error) {
+		atomic.AddInt64(&dialCount, 1)
+		return &fakeClient{runErr: errors.New("boom")}, nil
+	}
+
+	result, err := Run(cfg)
+	if err == nil {
+		t.Fatal("expected Run to return an error when every dialed host fails")
+	}
+	if got := atomic.LoadInt64(&dialCount); got != 1 {
+		t.Fatalf("dial was called %d times, want exactly 1 - queued hosts should be skipped once canceled", got)
+	}
+
+	var sawCanceled bool
+	for _, hr := range result.Hosts {
+		if errors.Is(hr.Error, context.Canceled) {
+			sawCanceled = true
+		}
+	}
+	if !sawCanceled {
+		t.Fatal("expected the hosts skipped after fail-fast to report context.Canceled")
+	}
+}
+
+// TestRun_Sequential is a baseline happy path covering Run end to end with Parallel unset, exercised
+// together with the above under `go test -race` to prove the worker pool and error accounting are free of
+// the data race the unbounded goroutine-per-host design had.
+func TestRun_Sequential(t *testing.T) {
+	cfg := baseConfig(t, hostsNamed(4))
+
+	var calls int64
+	var mu sync.Mutex
+	var seen []string
+	orig := dial
+	defer func() { dial = orig }()
+	dial = func(ssh.Config) (sshClient, error) {
+		atomic.AddInt64(&calls, 1)
+		mu.Lock()
+		seen = append(seen, fmt.Sprintf("call-%d", len(seen)))
+		mu.Unlock()
+		return &fakeClient{}, nil
+	}
+
+	result, err := Run(cfg)
+	if err != nil {
+		t.Fatalf("Run returned unexpected error: %s", err)
+	}
+	if result.Failed != 0 {
+		t.Fatalf("expected no failures, got %d", result.Failed)
+	}
+	if result.Changed != len(cfg.Hosts) {
+		t.Fatalf("expected %d changed tasks (one per host), got %d", len(cfg.Hosts), result.Changed)
+	}
+	if got := atomic.LoadInt64(&calls); int(got) != len(cfg.Hosts) {
+		t.Fatalf("dial was called %d times, want once per host (%d)", got, len(cfg.Hosts))
+	}
+	if len(seen) != len(cfg.Hosts) {
+		t.Fatalf("recorded %d calls, want %d", len(seen), len(cfg.Hosts))
+	}
+}
@@ -0,0 +1,96 @@
+// Package config provides the configuration structures used throughout Efs2.
+package config
+
+// Host describes a single remote target to execute an Efs2file against, along with any
+// per-host overrides and variables resolved from the inventory.
+type Host struct {
+	// Name is the hostname or address of the target. A ":port" suffix on Name takes precedence over Port.
+	Name string
+
+	// User overrides Config.User for this host when set.
+	User string
+
+	// Port overrides Config.Port for this host when set.
+	Port string
+
+	// KeyFile overrides Config.KeyFile for this host when set.
+	KeyFile string
+
+	// Groups are the inventory groups this host belongs to.
+	Groups []string
+
+	// Tags are the inventory tags assigned to this host.
+	Tags []string
+
+	// Vars holds per-host template variables available to RUN/PUT lines via {{ .Name }}.
+	Vars map[string]string
+}
+
+// Config holds the runtime configuration for an Efs2 execution.
+type Config struct {
+	// Verbose enables additional logging output.
+	Verbose bool
+
+	// Quiet suppresses all per-host logging output, including task failures and connection errors; Run still
+	// returns an error when any host fails.
+	Quiet bool
+
+	// Efs2File is the path to the Efs2file to execute.
+	Efs2File string
+
+	// KeyFile is the path to the SSH private key to use for authentication.
+	KeyFile string
+
+	// Passphrase is the passphrase used to decrypt an encrypted private key.
+	Passphrase string
+
+	// Password is the SSH password to use for authentication.
+	Password string
+
+	// User is the remote host username to authenticate as.
+	User string
+
+	// Port is the default SSH port to use for hosts that do not specify one.
+	Port string
+
+	// InventoryFile is the path to a YAML or INI inventory describing Hosts, their groups, tags and vars.
+	// A ".ini" extension selects the INI parser; anything else is parsed as YAML.
+	InventoryFile string
+
+	// Limit restricts execution to a comma separated list of group names and "tag:" selectors,
+	// e.g. "group1,tag:web".
+	Limit string
+
+	// Output selects the console reporter: "text" (default) for colored output, or "json" for JSON Lines.
+	Output string
+
+	// ReportPath, when set, additionally writes a JSON Lines report of every task result to this path.
+	ReportPath string
+
+	// Hosts is the list of remote hosts to execute the Efs2file against.
+	Hosts []Host
+
+	// Parallel, when true, executes tasks against all hosts concurrently, bounded by Forks.
+	Parallel bool
+
+	// Forks caps how many hosts are executed against concurrently when Parallel is set.
+	Forks int
+
+	// FailFast, when true, cancels in-flight and queued work as soon as any host fails.
+	FailFast bool
+
+	// DryRun, when true, prints the tasks that would be executed without running them.
+	DryRun bool
+
+	// InsecureHostKey, when true, skips known_hosts verification of remote host keys.
+	InsecureHostKey bool
+}
+
+// New returns a Config populated with sane defaults.
+func New() Config {
+	return Config{
+		Efs2File: "./Efs2file",
+		Port:     "22",
+		Forks:    5,
+	}
+}
@@ -0,0 +1,171 @@
+// Package inventory parses YAML or INI Efs2 inventory files describing hosts, the groups and tags they
+// belong to, and per-host variables, so a single Efs2file can drive a heterogeneous fleet the way
+// Ansible-style inventories do.
+package inventory
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/madflojo/efs2/config"
+	"gopkg.in/ini.v1"
+	"gopkg.in/yaml.v2"
+)
+
+// entry is the on-disk shape of a single host in the inventory file.
+type entry struct {
+	Name    string            `yaml:"name"`
+	User    string            `yaml:"user"`
+	Port    string            `yaml:"port"`
+	KeyFile string            `yaml:"keyfile"`
+	Groups  []string          `yaml:"groups"`
+	Tags    []string          `yaml:"tags"`
+	Vars    map[string]string `yaml:"vars"`
+}
+
+// file is the on-disk shape of the inventory file as a whole.
+type file struct {
+	Hosts []entry `yaml:"hosts"`
+}
+
+// Parse reads the inventory file at path and returns the hosts it describes. Files ending in ".ini" are
+// parsed as INI, one section per host; everything else is parsed as YAML.
+func Parse(path string) ([]config.Host, error) {
+	if strings.EqualFold(filepath.Ext(path), ".ini") {
+		return parseINI(path)
+	}
+	return parseYAML(path)
+}
+
+// parseYAML reads the YAML inventory file at path and returns the hosts it describes.
+func parseYAML(path string) ([]config.Host, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read inventory file - %s", err)
+	}
+
+	var f file
+	if err := yaml.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("could not parse inventory file - %s", err)
+	}
+
+	var hosts []config.Host
+	for _, e := range f.Hosts {
+		if e.Name == "" {
+			return nil, fmt.Errorf("inventory contains a host with no name")
+		}
+		hosts = append(hosts, config.Host{
+			Name:    e.Name,
+			User:    e.User,
+			Port:    e.Port,
+			KeyFile: e.KeyFile,
+			Groups:  e.Groups,
+			Tags:    e.Tags,
+			Vars:    e.Vars,
+		})
+	}
+
+	return hosts, nil
+}
+
+// parseINI reads the INI inventory file at path and returns the hosts it describes. Each section is one
+// host, named after the section; "user", "port" and "keyfile" map to the matching Host field, "groups" and
+// "tags" are comma separated lists, and any "vars.NAME" key becomes Vars[NAME].
+func parseINI(path string) ([]config.Host, error) {
+	f, err := ini.Load(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read inventory file - %s", err)
+	}
+
+	var hosts []config.Host
+	for _, sec := range f.Sections() {
+		if sec.Name() == ini.DefaultSection {
+			continue
+		}
+
+		h := config.Host{Name: sec.Name()}
+		vars := map[string]string{}
+		for _, key := range sec.Keys() {
+			switch {
+			case key.Name() == "user":
+				h.User = key.String()
+			case key.Name() == "port":
+				h.Port = key.String()
+			case key.Name() == "keyfile":
+				h.KeyFile = key.String()
+			case key.Name() == "groups":
+				h.Groups = splitList(key.String())
+			case key.Name() == "tags":
+				h.Tags = splitList(key.String())
+			case strings.HasPrefix(key.Name(), "vars."):
+				vars[strings.TrimPrefix(key.Name(), "vars.")] = key.String()
+			default:
+				return nil, fmt.Errorf("inventory section %q has unknown key %q", sec.Name(), key.Name())
+			}
+		}
+		if len(vars) > 0 {
+			h.Vars = vars
+		}
+
+		hosts = append(hosts, h)
+	}
+
+	return hosts, nil
+}
+
+// splitList splits a comma separated INI value into its trimmed, non-empty elements.
+func splitList(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// Filter returns the subset of hosts selected by limit, a comma separated list of group names and
+// "tag:name" selectors (e.g. "group1,tag:web"). A host matches if it belongs to any listed group or
+// carries any listed tag. An empty limit selects every host.
+func Filter(hosts []config.Host, limit string) []config.Host {
+	if limit == "" {
+		return hosts
+	}
+
+	var groups, tags []string
+	for _, sel := range strings.Split(limit, ",") {
+		sel = strings.TrimSpace(sel)
+		if sel == "" {
+			continue
+		}
+		if strings.HasPrefix(sel, "tag:") {
+			tags = append(tags, strings.TrimPrefix(sel, "tag:"))
+			continue
+		}
+		groups = append(groups, sel)
+	}
+
+	var matched []config.Host
+	for _, h := range hosts {
+		if contains(h.Groups, groups) || contains(h.Tags, tags) {
+			matched = append(matched, h)
+		}
+	}
+
+	return matched
+}
+
+// contains reports whether any of vals is present in list.
+func contains(list []string, vals []string) bool {
+	for _, v := range vals {
+		for _, l := range list {
+			if l == v {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,124 @@
+package inventory
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/madflojo/efs2/config"
+)
+
+func writeInventory(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("could not write inventory file - %s", err)
+	}
+	return path
+}
+
+func TestParse_YAML(t *testing.T) {
+	path := writeInventory(t, "hosts.yml", `
+hosts:
+  - name: web1
+    user: deploy
+    port: "2222"
+    groups: [web, prod]
+    tags: [critical]
+    vars:
+      region: us-east
+  - name: web2
+    groups: [web]
+`)
+
+	hosts, err := Parse(path)
+	if err != nil {
+		t.Fatalf("Parse returned unexpected error: %s", err)
+	}
+
+	want := []config.Host{
+		{Name: "web1", User: "deploy", Port: "2222", Groups: []string{"web", "prod"}, Tags: []string{"critical"}, Vars: map[string]string{"region": "us-east"}},
+		{Name: "web2", Groups: []string{"web"}},
+	}
+	if !reflect.DeepEqual(hosts, want) {
+		t.Fatalf("got %+v, want %+v", hosts, want)
+	}
+}
+
+func TestParse_YAML_missingName(t *testing.T) {
+	path := writeInventory(t, "hosts.yml", "hosts:\n  - user: deploy\n")
+
+	if _, err := Parse(path); err == nil {
+		t.Fatal("expected an error for a host with no name")
+	}
+}
+
+func TestParse_INI(t *testing.T) {
+	path := writeInventory(t, "hosts.ini", `
+[web1]
+user = deploy
+port = 2222
+keyfile = /keys/web1
+groups = web,prod
+tags = critical
+vars.region = us-east
+
+[web2]
+groups = web
+`)
+
+	hosts, err := Parse(path)
+	if err != nil {
+		t.Fatalf("Parse returned unexpected error: %s", err)
+	}
+
+	sort.Slice(hosts, func(i, j int) bool { return hosts[i].Name < hosts[j].Name })
+
+	want := []config.Host{
+		{Name: "web1", User: "deploy", Port: "2222", KeyFile: "/keys/web1", Groups: []string{"web", "prod"}, Tags: []string{"critical"}, Vars: map[string]string{"region": "us-east"}},
+		{Name: "web2", Groups: []string{"web"}},
+	}
+	if !reflect.DeepEqual(hosts, want) {
+		t.Fatalf("got %+v, want %+v", hosts, want)
+	}
+}
+
+func TestParse_INI_unknownKey(t *testing.T) {
+	path := writeInventory(t, "hosts.ini", "[web1]\nbogus = 1\n")
+
+	if _, err := Parse(path); err == nil {
+		t.Fatal("expected an error for an unrecognized INI key")
+	}
+}
+
+func TestFilter(t *testing.T) {
+	hosts := []config.Host{
+		{Name: "web1", Groups: []string{"web"}, Tags: []string{"critical"}},
+		{Name: "web2", Groups: []string{"web"}},
+		{Name: "db1", Groups: []string{"db"}, Tags: []string{"critical"}},
+	}
+
+	if got := Filter(hosts, ""); !reflect.DeepEqual(got, hosts) {
+		t.Fatalf("empty limit should select every host, got %+v", got)
+	}
+
+	got := Filter(hosts, "web")
+	want := []config.Host{hosts[0], hosts[1]}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("group selector: got %+v, want %+v", got, want)
+	}
+
+	got = Filter(hosts, "tag:critical")
+	want = []config.Host{hosts[0], hosts[2]}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("tag selector: got %+v, want %+v", got, want)
+	}
+
+	got = Filter(hosts, "db,tag:critical")
+	want = []config.Host{hosts[0], hosts[2]}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("combined selector: got %+v, want %+v", got, want)
+	}
+}
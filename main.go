@@ -10,18 +10,26 @@ import (
 	"github.com/jessevdk/go-flags"
 	"github.com/madflojo/efs2/app"
 	"github.com/madflojo/efs2/config"
+	"github.com/madflojo/efs2/inventory"
 	"os"
 )
 
 // options are command-line options that are provided by the user.
 type options struct {
-	Verbose  bool   `short:"v" long:"verbose" description:"Enable verbose output"`
-	Efs2File string `short:"f" long:"file" description:"Specify an alternative Efs2File" default:"./Efs2file"`
-	KeyFile  string `short:"i" long:"key" description:"Specify an SSH Private key to use (default: ~/.ssh/id_rsa)"`
-	Parallel bool   `short:"p" long:"parallel" description:"Execute tasks in parallel"`
-	DryRun   bool   `short:"d" long:"dryrun" description:"Print tasks to be executed without actually executing any tasks"`
-	Port     string `long:"port" description:"Define an alternate SSH Port" default:"22"`
-	User     string `short:"u" long:"user" description:"Remote host username (default: current user)"`
+	Verbose         bool   `short:"v" long:"verbose" description:"Enable verbose output"`
+	Efs2File        string `short:"f" long:"file" description:"Specify an alternative Efs2File" default:"./Efs2file"`
+	KeyFile         string `short:"i" long:"key" description:"Specify an SSH Private key to use (default: ~/.ssh/id_rsa)"`
+	Parallel        bool   `short:"p" long:"parallel" description:"Execute tasks in parallel"`
+	DryRun          bool   `short:"d" long:"dryrun" description:"Print tasks to be executed without actually executing any tasks"`
+	Port            string `long:"port" description:"Define an alternate SSH Port" default:"22"`
+	User            string `short:"u" long:"user" description:"Remote host username (default: current user)"`
+	Inventory       string `short:"I" long:"inventory" description:"Specify an inventory file describing hosts, groups, tags and vars"`
+	Limit           string `long:"limit" description:"Limit execution to a comma separated list of groups and tag:name selectors"`
+	Output          string `long:"output" description:"Console output format: text or json" default:"text"`
+	Report          string `long:"report" description:"Write a JSON Lines report of every task result to this path"`
+	Forks           int    `long:"forks" description:"Limit how many hosts run concurrently when --parallel is set" default:"5"`
+	FailFast        bool   `long:"fail-fast" description:"Cancel in-flight and queued work as soon as any host fails"`
+	InsecureHostKey bool   `long:"insecure-host-key" description:"Skip known_hosts verification of remote host keys"`
 }
 
 // main runs the command line parsing and validations. This function will also start the application logic execution.
@@ -48,10 +56,30 @@ func main() {
 	cfg.Parallel = opts.Parallel
 	cfg.DryRun = opts.DryRun
 	cfg.Port = opts.Port
-	cfg.Hosts = args
+	cfg.Limit = opts.Limit
+	cfg.Output = opts.Output
+	cfg.ReportPath = opts.Report
+	cfg.FailFast = opts.FailFast
+	cfg.InsecureHostKey = opts.InsecureHostKey
+	if opts.Forks > 0 {
+		cfg.Forks = opts.Forks
+	}
+
+	if opts.Inventory != "" {
+		cfg.InventoryFile = opts.Inventory
+		cfg.Hosts, err = inventory.Parse(opts.Inventory)
+		if err != nil {
+			color.Red("Error reading inventory: %s", err)
+			os.Exit(1)
+		}
+	} else {
+		for _, h := range args {
+			cfg.Hosts = append(cfg.Hosts, config.Host{Name: h})
+		}
+	}
 
 	// Run the App
-	err = app.Run(cfg)
+	_, err = app.Run(cfg)
 	if err != nil {
 		color.Red("Error executing: %s", err)
 		os.Exit(1)
@@ -13,6 +13,8 @@ import (
 )
 
 // Parse will open and read the specified Efs2file. It will then return a slice of tasks built from the file.
+// Handler tasks defined with HANDLER are returned alongside regular tasks with Task.IsHandler set; callers
+// should run them separately, once, for any handler a task's Notify fires.
 func Parse(f string) ([]ssh.Task, error) {
 	var tasks []ssh.Task
 	var s *bufio.Scanner
@@ -35,9 +37,26 @@ func Parse(f string) ([]ssh.Task, error) {
 	isOldRun := regexp.MustCompile(`^RUN (CMD|SCRIPT) .*$`)
 	// Matches PUT instructions
 	isPut := regexp.MustCompile(`^PUT .* \d{3,4}$`)
+	// Matches PUT_TEMPLATE instructions, which render a Go text/template before uploading
+	isPutTemplate := regexp.MustCompile(`^PUT_TEMPLATE .* \d{3,4}$`)
+	// Matches WHEN guards, which skip the next task unless a shell test exits 0 on the target
+	isWhen := regexp.MustCompile(`^WHEN .+$`)
+	// Matches UNLESS guards, which skip the next task if a shell test exits 0 on the target
+	isUnless := regexp.MustCompile(`^UNLESS .+$`)
+	// Matches ENV declarations, exported into every subsequent RUN command
+	isEnv := regexp.MustCompile(`^ENV \S+=.*$`)
+	// Matches HANDLER definitions, a named RUN task deferred until notified
+	isHandler := regexp.MustCompile(`^HANDLER \S+ RUN .+$`)
+	// Matches NOTIFY, which flags the previous task to run a named handler when it changes something
+	isNotify := regexp.MustCompile(`^NOTIFY \S+$`)
+	// Matches RETRY modifiers, attached to the previous task
+	isRetry := regexp.MustCompile(`^RETRY \d+ DELAY \S+$`)
 	// Matches Comments
 	isComment := regexp.MustCompile(`^#.*`)
 
+	env := map[string]string{}
+	var pendingWhen, pendingUnless string
+
 	lc := 0
 	for s.Scan() {
 		lc = lc + 1
@@ -45,7 +64,71 @@ func Parse(f string) ([]ssh.Task, error) {
 		l := strings.TrimSpace(s.Text())
 		c := strings.Split(l, " ")
 
-		if !isRun.MatchString(l) && !isOldRun.MatchString(l) && !isPut.MatchString(l) && !isComment.MatchString(l) {
+		switch {
+		case isComment.MatchString(l):
+			continue
+
+		case isEnv.MatchString(l):
+			kv := strings.SplitN(strings.TrimPrefix(l, "ENV "), "=", 2)
+			if len(kv) != 2 || kv[0] == "" {
+				return tasks, fmt.Errorf("ENV definition on line %d is incorrect", lc)
+			}
+			env[kv[0]] = kv[1]
+			continue
+
+		case isWhen.MatchString(l):
+			pendingWhen = strings.TrimPrefix(l, "WHEN ")
+			continue
+
+		case isUnless.MatchString(l):
+			pendingUnless = strings.TrimPrefix(l, "UNLESS ")
+			continue
+
+		case isHandler.MatchString(l):
+			if pendingWhen != "" || pendingUnless != "" {
+				return tasks, fmt.Errorf("WHEN/UNLESS cannot guard a HANDLER definition on line %d", lc)
+			}
+			fields := strings.SplitN(l, " ", 4)
+			if len(fields) != 4 {
+				return tasks, fmt.Errorf("HANDLER definition on line %d is incorrect", lc)
+			}
+			tasks = append(tasks, ssh.Task{
+				Task:      l,
+				IsHandler: true,
+				Name:      fields[1],
+				Command:   ssh.Command{Cmd: fields[3]},
+			})
+			continue
+
+		case isNotify.MatchString(l):
+			if len(tasks) == 0 {
+				return tasks, fmt.Errorf("NOTIFY on line %d has no preceding task", lc)
+			}
+			name := strings.TrimPrefix(l, "NOTIFY ")
+			tasks[len(tasks)-1].Notify = append(tasks[len(tasks)-1].Notify, name)
+			continue
+
+		case isRetry.MatchString(l):
+			if len(tasks) == 0 {
+				return tasks, fmt.Errorf("RETRY on line %d has no preceding task", lc)
+			}
+			fields := strings.Fields(l)
+			n, err := strconv.Atoi(fields[1])
+			if err != nil {
+				return tasks, fmt.Errorf("could not convert retry count to integer on line %d - %s", lc, fields[1])
+			}
+			d, err := time.ParseDuration(fields[3])
+			if err != nil {
+				return tasks, fmt.Errorf("could not parse retry delay on line %d - %s", lc, fields[3])
+			}
+			tasks[len(tasks)-1].Retries = n
+			tasks[len(tasks)-1].RetryDelay = d
+			continue
+
+		case isRun.MatchString(l) || isOldRun.MatchString(l) || isPut.MatchString(l) || isPutTemplate.MatchString(l):
+			// falls through to task construction below
+
+		default:
 			return tasks, fmt.Errorf("Unable to parse Efs2file line %s", l)
 		}
 
@@ -53,7 +136,11 @@ func Parse(f string) ([]ssh.Task, error) {
 			Task:    l,
 			Command: ssh.Command{},
 			File:    ssh.File{},
+			Env:     cloneEnv(env),
+			When:    pendingWhen,
+			Unless:  pendingUnless,
 		}
+		pendingWhen, pendingUnless = "", ""
 
 		// Match current RUN instruction syntax
 		if isRun.MatchString(l) && !isOldRun.MatchString(l) {
@@ -97,6 +184,27 @@ func Parse(f string) ([]ssh.Task, error) {
 
 			tasks = append(tasks, t)
 		}
+
+		// Match PUT_TEMPLATE instructions
+		if isPutTemplate.MatchString(l) {
+
+			p := strings.Split(l, " ")
+			if len(p) != 4 {
+				return tasks, fmt.Errorf("PUT_TEMPLATE definition on line %d is incorrect", lc)
+			}
+
+			t.File.Source = p[1]
+			t.File.Destination = p[2]
+			t.File.Template = true
+
+			m, err := strconv.ParseUint(p[3], 8, 32)
+			if err != nil {
+				return tasks, fmt.Errorf("could not convert mode value to integer on line %d - %s", lc, p[3])
+			}
+			t.File.Mode = os.FileMode(m)
+
+			tasks = append(tasks, t)
+		}
 	}
 	if err := s.Err(); err != nil {
 		return tasks, fmt.Errorf("error parsing Efs2file - %s", err)
@@ -105,10 +213,22 @@ func Parse(f string) ([]ssh.Task, error) {
 	return tasks, nil
 }
 
+// cloneEnv returns a copy of env so every Task owns its own snapshot of the ENV vars declared so far.
+func cloneEnv(env map[string]string) map[string]string {
+	if len(env) == 0 {
+		return nil
+	}
+	c := make(map[string]string, len(env))
+	for k, v := range env {
+		c[k] = v
+	}
+	return c
+}
+
 // TmpFn will generate a temporary filename
 func TmpFn() string {
 	// Snagged from ioutil.TempFile
 	r := uint32(time.Now().UnixNano() + int64(os.Getpid()))
 	r = r*1664525 + 1013904223
 	return strconv.Itoa(int(1e9 + r%1e9))[1:]
-}
\ No newline at end of file
+}
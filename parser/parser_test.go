@@ -0,0 +1,137 @@
+package parser
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeEfs2File(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "Efs2file")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("could not write Efs2file - %s", err)
+	}
+	return path
+}
+
+func TestParse_basicRun(t *testing.T) {
+	path := writeEfs2File(t, "RUN echo hi\n")
+
+	tasks, err := Parse(path)
+	if err != nil {
+		t.Fatalf("Parse returned unexpected error: %s", err)
+	}
+	if len(tasks) != 1 || tasks[0].Command.Cmd != "echo hi" {
+		t.Fatalf("got %+v", tasks)
+	}
+}
+
+func TestParse_whenUnlessGuardTheNextTask(t *testing.T) {
+	path := writeEfs2File(t, "WHEN test -f /tmp/x\nRUN echo a\nUNLESS test -f /tmp/y\nRUN echo b\n")
+
+	tasks, err := Parse(path)
+	if err != nil {
+		t.Fatalf("Parse returned unexpected error: %s", err)
+	}
+	if len(tasks) != 2 {
+		t.Fatalf("got %d tasks, want 2", len(tasks))
+	}
+	if tasks[0].When != "test -f /tmp/x" || tasks[0].Unless != "" {
+		t.Fatalf("task 0 guards: When=%q Unless=%q", tasks[0].When, tasks[0].Unless)
+	}
+	if tasks[1].Unless != "test -f /tmp/y" || tasks[1].When != "" {
+		t.Fatalf("task 1 guards: When=%q Unless=%q", tasks[1].When, tasks[1].Unless)
+	}
+}
+
+func TestParse_whenBeforeHandlerIsRejected(t *testing.T) {
+	path := writeEfs2File(t, "WHEN test -f /tmp/x\nHANDLER restart RUN systemctl restart app\n")
+
+	if _, err := Parse(path); err == nil {
+		t.Fatal("expected an error when a WHEN guard precedes a HANDLER definition")
+	}
+}
+
+func TestParse_handlerNotifyEnv(t *testing.T) {
+	path := writeEfs2File(t, "HANDLER restart RUN systemctl restart app\nENV FOO=bar\nRUN echo a\nNOTIFY restart\n")
+
+	tasks, err := Parse(path)
+	if err != nil {
+		t.Fatalf("Parse returned unexpected error: %s", err)
+	}
+	if len(tasks) != 2 {
+		t.Fatalf("got %d tasks, want 2", len(tasks))
+	}
+
+	handler := tasks[0]
+	if !handler.IsHandler || handler.Name != "restart" || handler.Command.Cmd != "systemctl restart app" {
+		t.Fatalf("got handler %+v", handler)
+	}
+
+	run := tasks[1]
+	if run.Env["FOO"] != "bar" {
+		t.Fatalf("expected ENV FOO=bar on the following RUN, got %+v", run.Env)
+	}
+	if len(run.Notify) != 1 || run.Notify[0] != "restart" {
+		t.Fatalf("expected NOTIFY restart on the preceding task, got %+v", run.Notify)
+	}
+}
+
+func TestParse_notifyWithNoPrecedingTask(t *testing.T) {
+	path := writeEfs2File(t, "NOTIFY restart\n")
+
+	if _, err := Parse(path); err == nil {
+		t.Fatal("expected an error for NOTIFY with no preceding task")
+	}
+}
+
+func TestParse_retryAttachesToPrecedingTask(t *testing.T) {
+	path := writeEfs2File(t, "RUN flaky-command\nRETRY 3 DELAY 500ms\n")
+
+	tasks, err := Parse(path)
+	if err != nil {
+		t.Fatalf("Parse returned unexpected error: %s", err)
+	}
+	if len(tasks) != 1 {
+		t.Fatalf("got %d tasks, want 1", len(tasks))
+	}
+	if tasks[0].Retries != 3 || tasks[0].RetryDelay != 500*time.Millisecond {
+		t.Fatalf("got Retries=%d RetryDelay=%s", tasks[0].Retries, tasks[0].RetryDelay)
+	}
+}
+
+func TestParse_retryWithNoPrecedingTask(t *testing.T) {
+	path := writeEfs2File(t, "RETRY 3 DELAY 500ms\n")
+
+	if _, err := Parse(path); err == nil {
+		t.Fatal("expected an error for RETRY with no preceding task")
+	}
+}
+
+func TestParse_putAndPutTemplate(t *testing.T) {
+	path := writeEfs2File(t, "PUT ./local /etc/app 0644\nPUT_TEMPLATE ./local.tmpl /etc/app.conf 0600\n")
+
+	tasks, err := Parse(path)
+	if err != nil {
+		t.Fatalf("Parse returned unexpected error: %s", err)
+	}
+	if len(tasks) != 2 {
+		t.Fatalf("got %d tasks, want 2", len(tasks))
+	}
+	if tasks[0].File.Source != "./local" || tasks[0].File.Destination != "/etc/app" || tasks[0].File.Mode != 0644 || tasks[0].File.Template {
+		t.Fatalf("got PUT task %+v", tasks[0])
+	}
+	if tasks[1].File.Source != "./local.tmpl" || tasks[1].File.Mode != 0600 || !tasks[1].File.Template {
+		t.Fatalf("got PUT_TEMPLATE task %+v", tasks[1])
+	}
+}
+
+func TestParse_unknownLineErrors(t *testing.T) {
+	path := writeEfs2File(t, "BOGUS thing\n")
+
+	if _, err := Parse(path); err == nil {
+		t.Fatal("expected an error for an unrecognized line")
+	}
+}
@@ -0,0 +1,170 @@
+// Package report renders the outcome of an Efs2 run, either as colored text for a terminal or as
+// JSON Lines suitable for CI pipelines and log aggregation systems.
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// Result is the outcome of a single task executed against a single host.
+type Result struct {
+	Host     string        `json:"host"`
+	Task     int           `json:"task"`
+	TaskText string        `json:"task_text"`
+	Stdout   string        `json:"stdout,omitempty"`
+	Stderr   string        `json:"stderr,omitempty"`
+	ExitCode int           `json:"exit_code"`
+	Start    time.Time     `json:"start"`
+	End      time.Time     `json:"end"`
+	Duration time.Duration `json:"duration_ns"`
+	Changed  bool          `json:"changed"`
+	Failed   bool          `json:"failed"`
+}
+
+// Summary is the final outcome of a run, reported once execution has finished.
+type Summary struct {
+	Hosts    int           `json:"hosts"`
+	Tasks    int           `json:"tasks"`
+	Changed  int           `json:"changed"`
+	Failed   int           `json:"failed"`
+	Duration time.Duration `json:"duration_ns"`
+}
+
+// Reporter receives task results and connection errors as a run progresses, and a final Summary once it
+// completes.
+type Reporter interface {
+	// TaskStart reports that host is about to execute task idx.
+	TaskStart(host string, idx int, text string)
+
+	// TaskDone reports the outcome of a completed task.
+	TaskDone(r Result)
+
+	// ConnectError reports that host could not be connected to.
+	ConnectError(host string, err error)
+
+	// Summary reports the final outcome of the run.
+	Summary(s Summary)
+}
+
+// New builds the Reporter for a run. output selects the console reporter ("json" or the default colored
+// text); if reportPath is set, a JSON Lines report is additionally written to that file. It returns the
+// Reporter and a close function that must be called once the run completes.
+func New(output string, reportPath string) (Reporter, func() error, error) {
+	var reporters []Reporter
+
+	if output == "json" {
+		reporters = append(reporters, &JSONReporter{w: os.Stdout})
+	} else {
+		reporters = append(reporters, &TextReporter{})
+	}
+
+	closeFn := func() error { return nil }
+	if reportPath != "" {
+		f, err := os.Create(reportPath)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not create report file - %s", err)
+		}
+		reporters = append(reporters, &JSONReporter{w: f})
+		closeFn = f.Close
+	}
+
+	if len(reporters) == 1 {
+		return reporters[0], closeFn, nil
+	}
+	return Multi(reporters), closeFn, nil
+}
+
+// TextReporter prints colored, human readable progress to the terminal. This is the default reporter and
+// uses Efs2's original console format; a failed task is printed as the host followed by its error text.
+type TextReporter struct{}
+
+// TaskStart implements Reporter.
+func (t *TextReporter) TaskStart(host string, idx int, text string) {
+	color.Blue("%s: Executing Task %d - %s", host, idx, text)
+}
+
+// TaskDone implements Reporter.
+func (t *TextReporter) TaskDone(r Result) {
+	if r.Failed {
+		color.Red("%s: %s", r.Host, r.Stderr)
+		return
+	}
+	color.Blue("%s: %s", r.Host, r.Stdout)
+}
+
+// ConnectError implements Reporter.
+func (t *TextReporter) ConnectError(host string, err error) {
+	color.Red("%s: Error connecting to host - %s", host, err)
+}
+
+// Summary implements Reporter.
+func (t *TextReporter) Summary(s Summary) {}
+
+// JSONReporter writes one JSON line per task result, plus a final summary object, to w.
+type JSONReporter struct {
+	w io.Writer
+}
+
+// TaskStart implements Reporter. JSONReporter only reports completed work, so this is a no-op.
+func (j *JSONReporter) TaskStart(host string, idx int, text string) {}
+
+// TaskDone implements Reporter.
+func (j *JSONReporter) TaskDone(r Result) {
+	j.encode(r)
+}
+
+// ConnectError implements Reporter.
+func (j *JSONReporter) ConnectError(host string, err error) {
+	j.encode(Result{Host: host, Failed: true, ExitCode: -1, Stderr: err.Error(), Start: time.Now(), End: time.Now()})
+}
+
+// Summary implements Reporter.
+func (j *JSONReporter) Summary(s Summary) {
+	j.encode(s)
+}
+
+// encode writes v as a single JSON line to w.
+func (j *JSONReporter) encode(v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	fmt.Fprintln(j.w, string(b))
+}
+
+// Multi fans every call out to each of its Reporters, so a run can report to both the console and a file.
+type Multi []Reporter
+
+// TaskStart implements Reporter.
+func (m Multi) TaskStart(host string, idx int, text string) {
+	for _, r := range m {
+		r.TaskStart(host, idx, text)
+	}
+}
+
+// TaskDone implements Reporter.
+func (m Multi) TaskDone(r Result) {
+	for _, rep := range m {
+		rep.TaskDone(r)
+	}
+}
+
+// ConnectError implements Reporter.
+func (m Multi) ConnectError(host string, err error) {
+	for _, r := range m {
+		r.ConnectError(host, err)
+	}
+}
+
+// Summary implements Reporter.
+func (m Multi) Summary(s Summary) {
+	for _, r := range m {
+		r.Summary(s)
+	}
+}
@@ -0,0 +1,138 @@
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJSONReporter_TaskDone(t *testing.T) {
+	var buf bytes.Buffer
+	j := &JSONReporter{w: &buf}
+
+	r := Result{Host: "web1", Task: 0, TaskText: "RUN echo hi", Stdout: "hi", Changed: true, Start: time.Now(), End: time.Now()}
+	j.TaskDone(r)
+
+	var got Result
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("could not decode JSON line - %s", err)
+	}
+	if got.Host != r.Host || got.Stdout != r.Stdout || !got.Changed {
+		t.Fatalf("got %+v, want fields from %+v", got, r)
+	}
+}
+
+func TestJSONReporter_ConnectError(t *testing.T) {
+	var buf bytes.Buffer
+	j := &JSONReporter{w: &buf}
+
+	j.ConnectError("web1", errDial)
+
+	var got Result
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("could not decode JSON line - %s", err)
+	}
+	if !got.Failed || got.Host != "web1" || got.Stderr != errDial.Error() {
+		t.Fatalf("got %+v", got)
+	}
+}
+
+func TestJSONReporter_Summary(t *testing.T) {
+	var buf bytes.Buffer
+	j := &JSONReporter{w: &buf}
+
+	j.Summary(Summary{Hosts: 2, Tasks: 3, Changed: 1, Failed: 1})
+
+	var got Summary
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("could not decode JSON line - %s", err)
+	}
+	if got.Hosts != 2 || got.Tasks != 3 || got.Changed != 1 || got.Failed != 1 {
+		t.Fatalf("got %+v", got)
+	}
+}
+
+func TestJSONReporter_TaskStart_isNoop(t *testing.T) {
+	var buf bytes.Buffer
+	j := &JSONReporter{w: &buf}
+
+	j.TaskStart("web1", 0, "RUN echo hi")
+
+	if buf.Len() != 0 {
+		t.Fatalf("expected TaskStart to write nothing, got %q", buf.String())
+	}
+}
+
+func TestMulti_fansOutToEveryReporter(t *testing.T) {
+	var a, b bytes.Buffer
+	m := Multi{&JSONReporter{w: &a}, &JSONReporter{w: &b}}
+
+	m.Summary(Summary{Hosts: 1})
+
+	if a.String() == "" || b.String() == "" {
+		t.Fatalf("expected both reporters to receive the summary, got a=%q b=%q", a.String(), b.String())
+	}
+	if a.String() != b.String() {
+		t.Fatalf("expected identical output, got a=%q b=%q", a.String(), b.String())
+	}
+}
+
+func TestNew_selectsReporterByOutput(t *testing.T) {
+	rep, closeFn, err := New("json", "")
+	if err != nil {
+		t.Fatalf("New returned unexpected error: %s", err)
+	}
+	defer closeFn()
+	if _, ok := rep.(*JSONReporter); !ok {
+		t.Fatalf("output \"json\" should select a *JSONReporter, got %T", rep)
+	}
+
+	rep, closeFn, err = New("text", "")
+	if err != nil {
+		t.Fatalf("New returned unexpected error: %s", err)
+	}
+	defer closeFn()
+	if _, ok := rep.(*TextReporter); !ok {
+		t.Fatalf("default output should select a *TextReporter, got %T", rep)
+	}
+}
+
+func TestNew_reportPathAddsJSONReporter(t *testing.T) {
+	dir := t.TempDir()
+	path := dir + "/report.jsonl"
+
+	rep, closeFn, err := New("text", path)
+	if err != nil {
+		t.Fatalf("New returned unexpected error: %s", err)
+	}
+
+	m, ok := rep.(Multi)
+	if !ok {
+		t.Fatalf("expected New to return a Multi when reportPath is set, got %T", rep)
+	}
+	if len(m) != 2 {
+		t.Fatalf("expected 2 reporters, got %d", len(m))
+	}
+
+	m.Summary(Summary{Hosts: 1})
+	if err := closeFn(); err != nil {
+		t.Fatalf("closeFn returned unexpected error: %s", err)
+	}
+
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("could not read report file - %s", err)
+	}
+	if !strings.Contains(string(contents), `"hosts":1`) {
+		t.Fatalf("expected report file to contain the summary, got %q", contents)
+	}
+}
+
+var errDial = dialErr("could not connect")
+
+type dialErr string
+
+func (e dialErr) Error() string { return string(e) }
@@ -0,0 +1,169 @@
+package ssh
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/kr/fs"
+	"github.com/pkg/sftp"
+)
+
+// SFTPClient wraps a single SFTP session to a remote host. A Client opens one lazily on its first Put and
+// reuses it for every subsequent upload to that host.
+type SFTPClient struct {
+	client *sftp.Client
+}
+
+// sftpClient returns this Client's cached SFTPClient, opening one if this is the first upload to the host.
+func (c *Client) sftpClient() (*SFTPClient, error) {
+	if c.sftp != nil {
+		return c.sftp, nil
+	}
+
+	s, err := sftp.NewClient(c.client)
+	if err != nil {
+		return nil, fmt.Errorf("could not open SFTP session - %s", err)
+	}
+
+	c.sftp = &SFTPClient{client: s}
+	return c.sftp, nil
+}
+
+// Uploader copies local files and directories to a remote host over an SFTPClient, skipping any file whose
+// content already matches what's on the remote end.
+type Uploader struct {
+	sftp *SFTPClient
+	run  func(Command) (string, error)
+}
+
+// Put uploads f to the remote host. If f.Source is a directory its contents are walked and mirrored under
+// f.Destination, with f.Mode applied to every uploaded file and a derived, traversable mode applied to
+// directories. If f.Template is set, f.Source (or each file beneath it) is rendered as a Go text/template
+// using vars before being compared and uploaded.
+func (c *Client) Put(f File, vars map[string]string) (bool, error) {
+	sc, err := c.sftpClient()
+	if err != nil {
+		return false, err
+	}
+	u := &Uploader{sftp: sc, run: c.Run}
+
+	info, err := os.Stat(f.Source)
+	if err != nil {
+		return false, fmt.Errorf("could not stat %s - %s", f.Source, err)
+	}
+
+	if !info.IsDir() {
+		return u.upload(f.Source, f.Destination, f.Mode, f.Template, vars)
+	}
+
+	changed := false
+	dirMode := (f.Mode & 0777) | 0111
+	walker := fs.Walk(f.Source)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			return changed, fmt.Errorf("could not walk %s - %s", f.Source, err)
+		}
+
+		rel, err := filepath.Rel(f.Source, walker.Path())
+		if err != nil {
+			return changed, fmt.Errorf("could not resolve %s relative to %s - %s", walker.Path(), f.Source, err)
+		}
+		dest := path.Join(f.Destination, filepath.ToSlash(rel))
+
+		if walker.Stat().IsDir() {
+			if err := sc.client.MkdirAll(dest); err != nil {
+				return changed, fmt.Errorf("could not create remote directory %s - %s", dest, err)
+			}
+			if err := sc.client.Chmod(dest, dirMode); err != nil {
+				return changed, fmt.Errorf("could not set mode on remote directory %s - %s", dest, err)
+			}
+			continue
+		}
+
+		fileChanged, err := u.upload(walker.Path(), dest, f.Mode, f.Template, vars)
+		if err != nil {
+			return changed, err
+		}
+		changed = changed || fileChanged
+	}
+
+	return changed, nil
+}
+
+// upload copies the single local file at src to dest on the remote host, skipping it if a SHA-256
+// comparison shows the remote file already holds the same content.
+func (u *Uploader) upload(src, dest string, mode os.FileMode, tmpl bool, vars map[string]string) (bool, error) {
+	content, err := ioutil.ReadFile(src)
+	if err != nil {
+		return false, fmt.Errorf("could not read %s - %s", src, err)
+	}
+
+	if tmpl {
+		content, err = renderTemplate(src, content, vars)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	sum := sha256.Sum256(content)
+	localSum := hex.EncodeToString(sum[:])
+
+	if remoteSum, err := u.remoteSHA256(dest); err == nil && remoteSum == localSum {
+		return false, nil
+	}
+
+	rf, err := u.sftp.client.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return false, fmt.Errorf("could not open remote file %s - %s", dest, err)
+	}
+	defer rf.Close()
+
+	if _, err := io.Copy(rf, bytes.NewReader(content)); err != nil {
+		return false, fmt.Errorf("could not write remote file %s - %s", dest, err)
+	}
+
+	if err := u.sftp.client.Chmod(dest, mode); err != nil {
+		return false, fmt.Errorf("could not set mode on remote file %s - %s", dest, err)
+	}
+
+	return true, nil
+}
+
+// remoteSHA256 runs sha256sum on the remote host and returns the hash of dest, or an error if dest does
+// not exist.
+func (u *Uploader) remoteSHA256(dest string) (string, error) {
+	out, err := u.run(Command{Cmd: fmt.Sprintf("sha256sum %s 2>/dev/null", ShellQuote(dest))})
+	if err != nil {
+		return "", err
+	}
+
+	fields := strings.Fields(out)
+	if len(fields) == 0 {
+		return "", fmt.Errorf("remote file %s does not exist", dest)
+	}
+	return fields[0], nil
+}
+
+// renderTemplate parses content as a Go text/template named after src and executes it with vars.
+func renderTemplate(src string, content []byte, vars map[string]string) ([]byte, error) {
+	t, err := template.New(filepath.Base(src)).Parse(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("could not parse template %s - %s", src, err)
+	}
+
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, vars); err != nil {
+		return nil, fmt.Errorf("could not render template %s - %s", src, err)
+	}
+
+	return buf.Bytes(), nil
+}
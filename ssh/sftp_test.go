@@ -0,0 +1,39 @@
+package ssh
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestUploader_remoteSHA256_quotesDestination(t *testing.T) {
+	var gotCmd string
+	u := &Uploader{run: func(c Command) (string, error) {
+		gotCmd = c.Cmd
+		return "deadbeef  " + "/tmp/dest with spaces; rm -rf /", nil
+	}}
+
+	dest := "/tmp/dest with spaces; rm -rf /"
+	sum, err := u.remoteSHA256(dest)
+	if err != nil {
+		t.Fatalf("remoteSHA256 returned unexpected error: %s", err)
+	}
+	if sum != "deadbeef" {
+		t.Fatalf("got sum %q, want %q", sum, "deadbeef")
+	}
+
+	want := fmt.Sprintf("sha256sum %s 2>/dev/null", ShellQuote(dest))
+	if gotCmd != want {
+		t.Fatalf("remote command was %q, want %q", gotCmd, want)
+	}
+	if gotCmd == fmt.Sprintf("sha256sum %s 2>/dev/null", dest) {
+		t.Fatal("destination was interpolated unquoted into the remote command")
+	}
+}
+
+func TestUploader_remoteSHA256_missingFile(t *testing.T) {
+	u := &Uploader{run: func(Command) (string, error) { return "", nil }}
+
+	if _, err := u.remoteSHA256("/tmp/missing"); err == nil {
+		t.Fatal("expected an error when sha256sum produces no output")
+	}
+}
@@ -0,0 +1,313 @@
+// Package ssh provides the SSH client functionality used by Efs2 to run commands and upload files to remote hosts.
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Config holds the connection details used to dial a remote host.
+type Config struct {
+	// Host is the remote host to connect to, in "host:port" form.
+	Host string
+
+	// User is the remote username to authenticate as.
+	User string
+
+	// Password is used for password authentication when set.
+	Password string
+
+	// Signers are used for public key authentication when set, tried in order.
+	Signers []ssh.Signer
+
+	// HostKeyCallback verifies the remote host key. Required; Dial refuses to connect if this is nil.
+	HostKeyCallback ssh.HostKeyCallback
+
+	// ProxyJump, when set, is a bastion host ("user@host:port") to tunnel the connection through.
+	ProxyJump string
+}
+
+// Command describes a single shell command to run on a remote host.
+type Command struct {
+	// Cmd is the shell command text.
+	Cmd string
+}
+
+// File describes a single file to upload to a remote host.
+type File struct {
+	// Source is the local path of the file to upload.
+	Source string
+
+	// Destination is the remote path to upload the file to.
+	Destination string
+
+	// Mode is the permission mode to apply to the remote file.
+	Mode os.FileMode
+
+	// Template marks Source as a Go text/template to be rendered before upload.
+	Template bool
+}
+
+// Task is a single unit of work parsed from an Efs2file, either a Command, a File upload, or both.
+type Task struct {
+	// Task is the raw Efs2file line this Task was parsed from.
+	Task string
+
+	Command Command
+	File    File
+
+	// When, if set, is a shell test that must exit 0 on the target for this task to run.
+	When string
+
+	// Unless, if set, is a shell test that must exit 0 on the target for this task to be skipped.
+	Unless string
+
+	// Env holds shell variables, declared via ENV, to export before this task's Command runs.
+	Env map[string]string
+
+	// Notify lists handler names to run, once, at the end of the host's run if this task changes something.
+	Notify []string
+
+	// Retries is the number of additional attempts to make if this task fails.
+	Retries int
+
+	// RetryDelay is how long to wait between retry attempts.
+	RetryDelay time.Duration
+
+	// IsHandler marks this Task as a named handler, run only when notified rather than inline.
+	IsHandler bool
+
+	// Name is this Task's handler name. Only set when IsHandler is true.
+	Name string
+}
+
+// Client is a connected SSH session to a single remote host.
+type Client struct {
+	cfg    Config
+	client *ssh.Client
+
+	// bastion is the connection to cfg.ProxyJump, set only when the host was reached through one, so
+	// Close can tear it down alongside client instead of leaking it.
+	bastion *ssh.Client
+
+	// sftp caches the SFTP session for this Client so every Put against this host reuses one connection.
+	sftp *SFTPClient
+}
+
+// ReadKeyFile reads and parses the private key at path, decrypting it with passphrase if required.
+func ReadKeyFile(path string, passphrase string) (Config, error) {
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return Config{}, fmt.Errorf("could not determine home directory - %s", err)
+		}
+		path = home + "/.ssh/id_rsa"
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Config{}, fmt.Errorf("could not read key file - %s", err)
+	}
+
+	var signer ssh.Signer
+	if passphrase != "" {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(b, []byte(passphrase))
+	} else {
+		signer, err = ssh.ParsePrivateKey(b)
+	}
+	if err != nil {
+		return Config{}, fmt.Errorf("could not decode encrypted key - %s", err)
+	}
+
+	return Config{Signers: []ssh.Signer{signer}}, nil
+}
+
+// AgentConfig returns a Config backed by the keys held by a running ssh-agent, for use as a fallback
+// auth method when no password or key file was supplied.
+func AgentConfig() (Config, error) {
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if sock == "" {
+		return Config{}, fmt.Errorf("SSH_AUTH_SOCK is not set")
+	}
+
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		return Config{}, fmt.Errorf("could not connect to ssh-agent - %s", err)
+	}
+
+	signers, err := agent.NewClient(conn).Signers()
+	if err != nil {
+		return Config{}, fmt.Errorf("could not list ssh-agent keys - %s", err)
+	}
+
+	return Config{Signers: signers}, nil
+}
+
+// KnownHostsCallback returns the host key verification callback to use when dialing. When insecure is
+// true it skips verification entirely; otherwise it verifies against ~/.ssh/known_hosts.
+func KnownHostsCallback(insecure bool) (ssh.HostKeyCallback, error) {
+	if insecure {
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("could not determine home directory - %s", err)
+	}
+
+	cb, err := knownhosts.New(home + "/.ssh/known_hosts")
+	if err != nil {
+		return nil, fmt.Errorf("could not read known_hosts - %s", err)
+	}
+
+	return cb, nil
+}
+
+// Dial connects to the host described by cfg and returns a ready to use Client. If cfg.ProxyJump is set,
+// the connection is tunneled through that bastion host. Dial fails closed: callers must set
+// cfg.HostKeyCallback explicitly (KnownHostsCallback, picking InsecureIgnoreHostKey if they really want
+// that) rather than relying on an insecure default here.
+func Dial(cfg Config) (*Client, error) {
+	if cfg.HostKeyCallback == nil {
+		return nil, fmt.Errorf("HostKeyCallback is required - use KnownHostsCallback to build one")
+	}
+
+	clientCfg := &ssh.ClientConfig{
+		User:            cfg.User,
+		Auth:            authMethods(cfg),
+		HostKeyCallback: cfg.HostKeyCallback,
+	}
+
+	var c, bastion *ssh.Client
+	var err error
+	if cfg.ProxyJump != "" {
+		c, bastion, err = dialViaJump(cfg, clientCfg)
+	} else {
+		c, err = ssh.Dial("tcp", cfg.Host, clientCfg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to %s - %s", cfg.Host, err)
+	}
+
+	return &Client{cfg: cfg, client: c, bastion: bastion}, nil
+}
+
+// authMethods builds the auth methods to offer for cfg, trying a password and/or public keys.
+func authMethods(cfg Config) []ssh.AuthMethod {
+	var auth []ssh.AuthMethod
+	if cfg.Password != "" {
+		auth = append(auth, ssh.Password(cfg.Password))
+	}
+	if len(cfg.Signers) > 0 {
+		auth = append(auth, ssh.PublicKeys(cfg.Signers...))
+	}
+	return auth
+}
+
+// dialViaJump connects to cfg.Host by first dialing cfg.ProxyJump, then tunneling the SSH connection
+// through it, so a chain like "bastion -> target" works transparently. It returns the bastion connection
+// alongside the tunneled one so the caller can keep it alive for as long as the tunnel is in use and close
+// it when the tunnel is torn down, instead of leaking it.
+func dialViaJump(cfg Config, clientCfg *ssh.ClientConfig) (*ssh.Client, *ssh.Client, error) {
+	jumpUser := cfg.User
+	jumpHost := cfg.ProxyJump
+	if i := strings.Index(jumpHost, "@"); i >= 0 {
+		jumpUser = jumpHost[:i]
+		jumpHost = jumpHost[i+1:]
+	}
+	if !strings.Contains(jumpHost, ":") {
+		jumpHost += ":22"
+	}
+
+	jumpCfg := *clientCfg
+	jumpCfg.User = jumpUser
+
+	bastion, err := ssh.Dial("tcp", jumpHost, &jumpCfg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not connect to bastion %s - %s", jumpHost, err)
+	}
+
+	conn, err := bastion.Dial("tcp", cfg.Host)
+	if err != nil {
+		bastion.Close()
+		return nil, nil, fmt.Errorf("could not reach %s via bastion %s - %s", cfg.Host, jumpHost, err)
+	}
+
+	ncc, chans, reqs, err := ssh.NewClientConn(conn, cfg.Host, clientCfg)
+	if err != nil {
+		bastion.Close()
+		return nil, nil, fmt.Errorf("could not establish SSH session with %s via bastion - %s", cfg.Host, err)
+	}
+
+	return ssh.NewClient(ncc, chans, reqs), bastion, nil
+}
+
+// Run executes cmd on the remote host and returns its combined output.
+func (c *Client) Run(cmd Command) (string, error) {
+	s, err := c.client.NewSession()
+	if err != nil {
+		return "", fmt.Errorf("could not open session - %s", err)
+	}
+	defer s.Close()
+
+	out, err := s.CombinedOutput(cmd.Cmd)
+	if err != nil {
+		return string(out), fmt.Errorf("could not run command - %w", err)
+	}
+
+	return string(out), nil
+}
+
+// ExitCode extracts the remote command's exit status from an error returned by Run. It returns 0 if err
+// is nil, or 1 if the exit status could not be determined.
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var ee *ssh.ExitError
+	if errors.As(err, &ee) {
+		return ee.ExitStatus()
+	}
+	return 1
+}
+
+// ShellQuote wraps s in single quotes, escaping any single quotes it contains, so it can be safely used
+// as a single POSIX shell word in a remote command.
+func ShellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'"'"'`) + "'"
+}
+
+// Check runs cmd on the remote host and reports whether it exited 0, so WHEN/UNLESS guards can evaluate
+// a shell test without treating a non-zero exit as a connection or session error.
+func (c *Client) Check(cmd string) (bool, error) {
+	_, err := c.Run(Command{Cmd: cmd})
+	if err == nil {
+		return true, nil
+	}
+
+	var ee *ssh.ExitError
+	if errors.As(err, &ee) {
+		return false, nil
+	}
+	return false, err
+}
+
+// Close terminates the underlying SSH connection, and the bastion connection tunneling it if one was used.
+func (c *Client) Close() error {
+	err := c.client.Close()
+	if c.bastion != nil {
+		if berr := c.bastion.Close(); err == nil {
+			err = berr
+		}
+	}
+	return err
+}
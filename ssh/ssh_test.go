@@ -0,0 +1,37 @@
+package ssh
+
+import "testing"
+
+func TestShellQuote(t *testing.T) {
+	cases := []struct {
+		in   string
+		want string
+	}{
+		{"plain", "'plain'"},
+		{"has space", "'has space'"},
+		{"it's", `'it'"'"'s'`},
+		{"; rm -rf /", "'; rm -rf /'"},
+	}
+
+	for _, c := range cases {
+		if got := ShellQuote(c.in); got != c.want {
+			t.Errorf("ShellQuote(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
+
+func TestDial_requiresHostKeyCallback(t *testing.T) {
+	if _, err := Dial(Config{Host: "127.0.0.1:1"}); err == nil {
+		t.Fatal("expected Dial to fail closed when HostKeyCallback is nil")
+	}
+}
+
+func TestKnownHostsCallback_insecure(t *testing.T) {
+	cb, err := KnownHostsCallback(true)
+	if err != nil {
+		t.Fatalf("KnownHostsCallback returned unexpected error: %s", err)
+	}
+	if cb == nil {
+		t.Fatal("expected a non-nil callback when insecure is true")
+	}
+}
@@ -0,0 +1,71 @@
+// Package sshconfig resolves per-host connection settings from the user's ~/.ssh/config, the same way
+// the openssh client would, so Efs2 behaves consistently with a user's existing SSH setup.
+package sshconfig
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/kevinburke/ssh_config"
+)
+
+// Host holds the settings resolved for a single alias out of ~/.ssh/config.
+type Host struct {
+	// HostName is the effective address to connect to, which may differ from the alias.
+	HostName string
+
+	// User overrides the connecting username when set.
+	User string
+
+	// Port overrides the connecting port when set.
+	Port string
+
+	// IdentityFile overrides the private key path when set.
+	IdentityFile string
+
+	// ProxyJump, when set, is the bastion host to tunnel the connection through.
+	ProxyJump string
+}
+
+// Resolve looks up alias in ~/.ssh/config and returns the settings configured for it. A missing config
+// file is not an error - Resolve simply returns a zero Host, leaving every setting to its caller's
+// defaults.
+func Resolve(alias string) (Host, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return Host{}, fmt.Errorf("could not determine home directory - %s", err)
+	}
+
+	f, err := os.Open(home + "/.ssh/config")
+	if err != nil {
+		if os.IsNotExist(err) {
+			return Host{}, nil
+		}
+		return Host{}, fmt.Errorf("could not read ssh config - %s", err)
+	}
+	defer f.Close()
+
+	cfg, err := ssh_config.Decode(f)
+	if err != nil {
+		return Host{}, fmt.Errorf("could not parse ssh config - %s", err)
+	}
+
+	h := Host{}
+	if v, err := cfg.Get(alias, "HostName"); err == nil && v != "" {
+		h.HostName = v
+	}
+	if v, err := cfg.Get(alias, "User"); err == nil && v != "" {
+		h.User = v
+	}
+	if v, err := cfg.Get(alias, "Port"); err == nil && v != "" {
+		h.Port = v
+	}
+	if v, err := cfg.Get(alias, "IdentityFile"); err == nil && v != "" {
+		h.IdentityFile = v
+	}
+	if v, err := cfg.Get(alias, "ProxyJump"); err == nil && v != "" {
+		h.ProxyJump = v
+	}
+
+	return h, nil
+}
@@ -0,0 +1,69 @@
+package sshconfig
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func withHome(t *testing.T, sshConfig string) {
+	t.Helper()
+	home := t.TempDir()
+	if sshConfig != "" {
+		if err := os.MkdirAll(filepath.Join(home, ".ssh"), 0700); err != nil {
+			t.Fatalf("could not create .ssh dir - %s", err)
+		}
+		if err := os.WriteFile(filepath.Join(home, ".ssh", "config"), []byte(sshConfig), 0600); err != nil {
+			t.Fatalf("could not write ssh config - %s", err)
+		}
+	}
+	t.Setenv("HOME", home)
+}
+
+func TestResolve_missingConfigIsNotAnError(t *testing.T) {
+	withHome(t, "")
+
+	h, err := Resolve("web1")
+	if err != nil {
+		t.Fatalf("Resolve returned unexpected error: %s", err)
+	}
+	if h != (Host{}) {
+		t.Fatalf("expected a zero Host, got %+v", h)
+	}
+}
+
+func TestResolve_appliesMatchingHostBlock(t *testing.T) {
+	withHome(t, `
+Host web1
+  HostName 10.0.0.1
+  User deploy
+  Port 2222
+  IdentityFile ~/.ssh/web1
+  ProxyJump bastion
+
+Host other
+  HostName 10.0.0.9
+`)
+
+	h, err := Resolve("web1")
+	if err != nil {
+		t.Fatalf("Resolve returned unexpected error: %s", err)
+	}
+
+	want := Host{HostName: "10.0.0.1", User: "deploy", Port: "2222", IdentityFile: "~/.ssh/web1", ProxyJump: "bastion"}
+	if h != want {
+		t.Fatalf("got %+v, want %+v", h, want)
+	}
+}
+
+func TestResolve_unmatchedAliasIsZeroValue(t *testing.T) {
+	withHome(t, "Host web1\n  HostName 10.0.0.1\n")
+
+	h, err := Resolve("web2")
+	if err != nil {
+		t.Fatalf("Resolve returned unexpected error: %s", err)
+	}
+	if h != (Host{}) {
+		t.Fatalf("expected a zero Host for an alias with no matching block, got %+v", h)
+	}
+}